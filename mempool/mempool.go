@@ -0,0 +1,285 @@
+// Package mempool buffers validated, not-yet-included transactions
+// ahead of block assembly. It sits between tx submission and the
+// block-assembly worker so that a burst of txs larger than a single
+// block interval queues up instead of being rejected outright,
+// mirroring the txpool/worker split in go-ethereum.
+package mempool
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// ErrDuplicate is returned by Add when a tx with the same ID is
+// already pending.
+var ErrDuplicate = errors.New("duplicate tx")
+
+// ErrFull is returned by Add when the pool is at Config.MaxSize and
+// tx doesn't outrank the pool's lowest-priority entry.
+var ErrFull = errors.New("mempool full")
+
+// maxRequeueAttempts bounds how many times Requeue will accept the
+// same tx back into the pool. A tx that keeps losing to AddTx (e.g.
+// because it permanently conflicts with another tx that always beats
+// it into the block) is dropped rather than requeued forever.
+const maxRequeueAttempts = 5
+
+// Tx is a pending transaction together with the bookkeeping the pool
+// uses to prioritize and expire it.
+type Tx struct {
+	Tx        *bc.Tx
+	Submitter string
+	Size      int
+	Added     time.Time
+
+	// Attempts counts how many times this entry has been Pull'd and
+	// then Requeue'd after failing to make it into a block.
+	Attempts int
+}
+
+// PriorityFunc ranks pending txs; higher sorts first.
+type PriorityFunc func(tx *Tx) float64
+
+// RunlimitPerByte is the default PriorityFunc: it favors txs with a
+// high runlimit-to-size ratio, which do the most chain work per byte
+// of block space they occupy. Ties (including Size 0) fall back to
+// arrival time, earliest first, via the stable sort in Pull.
+func RunlimitPerByte(tx *Tx) float64 {
+	if tx.Size <= 0 {
+		return 0
+	}
+	return float64(tx.Tx.Runlimit) / float64(tx.Size)
+}
+
+// Config configures a Pool.
+type Config struct {
+	// MaxSize caps the number of pending txs. Add evicts the
+	// lowest-priority entry to make room for a higher-priority one;
+	// if the new tx is the lowest-priority, it's rejected instead.
+	// Zero means unbounded.
+	MaxSize int
+
+	// TTL expires a tx that's been pending longer than this. Zero
+	// means txs never expire on their own.
+	TTL time.Duration
+
+	// Priority ranks pending txs. Defaults to RunlimitPerByte.
+	Priority PriorityFunc
+}
+
+// Stats summarizes a Pool's contents.
+type Stats struct {
+	Count      int
+	Submitters int
+	OldestTx   time.Time
+}
+
+// Pool is a mempool of pending txs, keyed by ID for duplicate
+// detection and bucketed by submitter so Pull can draw from
+// different submitters' queues in turn instead of one submitter's
+// burst starving everyone else.
+type Pool struct {
+	cfg Config
+
+	mu          sync.Mutex
+	byID        map[bc.Hash]*Tx
+	bySubmitter map[string][]*Tx
+}
+
+// New creates an empty Pool. A zero cfg.Priority defaults to
+// RunlimitPerByte.
+func New(cfg Config) *Pool {
+	if cfg.Priority == nil {
+		cfg.Priority = RunlimitPerByte
+	}
+	return &Pool{
+		cfg:         cfg,
+		byID:        make(map[bc.Hash]*Tx),
+		bySubmitter: make(map[string][]*Tx),
+	}
+}
+
+// Add validates tx for duplication and pool capacity and, if
+// accepted, queues it under submitter. It does not itself check tx
+// against chain state; callers are expected to have already built
+// and validated tx (e.g. via bc.NewTx) against the latest snapshot.
+func (p *Pool) Add(tx *bc.Tx, submitter string, size int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpiredLocked()
+
+	if _, ok := p.byID[tx.ID]; ok {
+		return ErrDuplicate
+	}
+
+	entry := &Tx{Tx: tx, Submitter: submitter, Size: size, Added: time.Now()}
+
+	if p.cfg.MaxSize > 0 && len(p.byID) >= p.cfg.MaxSize {
+		lowest := p.lowestPriorityLocked()
+		if lowest == nil || p.cfg.Priority(lowest) >= p.cfg.Priority(entry) {
+			return ErrFull
+		}
+		p.removeLocked(lowest)
+	}
+
+	p.byID[tx.ID] = entry
+	p.bySubmitter[submitter] = append(p.bySubmitter[submitter], entry)
+	return nil
+}
+
+// Pull removes and returns up to maxCount pending txs whose combined
+// Runlimit doesn't exceed runlimitBudget, drawing round-robin from
+// each submitter's highest-priority tx so one submitter's backlog
+// can't starve the others. Callers that fail to land a pulled tx in a
+// block (e.g. it conflicts with another tx already folded in) should
+// call Requeue rather than discarding it.
+func (p *Pool) Pull(runlimitBudget int64, maxCount int) []*Tx {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpiredLocked()
+
+	submitters := make([]string, 0, len(p.bySubmitter))
+	for s, q := range p.bySubmitter {
+		sort.SliceStable(q, func(i, j int) bool {
+			return p.cfg.Priority(q[i]) > p.cfg.Priority(q[j])
+		})
+		submitters = append(submitters, s)
+	}
+
+	var (
+		out  []*Tx
+		used int64
+	)
+	for len(submitters) > 0 && (maxCount <= 0 || len(out) < maxCount) {
+		progressed := false
+		for i := 0; i < len(submitters); {
+			s := submitters[i]
+			q := p.bySubmitter[s]
+			if len(q) == 0 {
+				submitters = append(submitters[:i], submitters[i+1:]...)
+				continue
+			}
+			head := q[0]
+			if used+head.Tx.Runlimit > runlimitBudget {
+				i++
+				continue
+			}
+			out = append(out, head)
+			used += head.Tx.Runlimit
+			delete(p.byID, head.Tx.ID)
+			p.bySubmitter[s] = q[1:]
+			progressed = true
+			i++
+			if maxCount > 0 && len(out) >= maxCount {
+				break
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+
+	for s, q := range p.bySubmitter {
+		if len(q) == 0 {
+			delete(p.bySubmitter, s)
+		}
+	}
+	return out
+}
+
+// Requeue re-admits entry, which must have come from a prior Pull,
+// back into the pool for reconsideration by a later Pull. It reports
+// whether entry was requeued; once entry has been Pull'd and
+// Requeue'd maxRequeueAttempts times, Requeue leaves it out and
+// returns false, so a tx that can never land in a block doesn't sit
+// in the pool forever.
+func (p *Pool) Requeue(entry *Tx) bool {
+	entry.Attempts++
+	if entry.Attempts > maxRequeueAttempts {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.byID[entry.Tx.ID] = entry
+	p.bySubmitter[entry.Submitter] = append(p.bySubmitter[entry.Submitter], entry)
+	return true
+}
+
+// IDs returns the IDs of all currently pending txs.
+func (p *Pool) IDs() []bc.Hash {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpiredLocked()
+
+	ids := make([]bc.Hash, 0, len(p.byID))
+	for id := range p.byID {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Stats returns a snapshot of the pool's current contents.
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.evictExpiredLocked()
+
+	stats := Stats{Count: len(p.byID), Submitters: len(p.bySubmitter)}
+	for _, entry := range p.byID {
+		if stats.OldestTx.IsZero() || entry.Added.Before(stats.OldestTx) {
+			stats.OldestTx = entry.Added
+		}
+	}
+	return stats
+}
+
+// evictExpiredLocked removes txs older than cfg.TTL. Callers must
+// hold p.mu.
+func (p *Pool) evictExpiredLocked() {
+	if p.cfg.TTL <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-p.cfg.TTL)
+	for _, entry := range p.byID {
+		if entry.Added.Before(cutoff) {
+			p.removeLocked(entry)
+		}
+	}
+}
+
+// lowestPriorityLocked returns the pending tx with the lowest
+// priority, or nil if the pool is empty. Callers must hold p.mu.
+func (p *Pool) lowestPriorityLocked() *Tx {
+	var lowest *Tx
+	for _, entry := range p.byID {
+		if lowest == nil || p.cfg.Priority(entry) < p.cfg.Priority(lowest) {
+			lowest = entry
+		}
+	}
+	return lowest
+}
+
+// removeLocked drops entry from both indexes. Callers must hold p.mu.
+func (p *Pool) removeLocked(entry *Tx) {
+	delete(p.byID, entry.Tx.ID)
+	q := p.bySubmitter[entry.Submitter]
+	for i, e := range q {
+		if e == entry {
+			p.bySubmitter[entry.Submitter] = append(q[:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(p.bySubmitter[entry.Submitter]) == 0 {
+		delete(p.bySubmitter, entry.Submitter)
+	}
+}
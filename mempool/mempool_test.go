@@ -0,0 +1,131 @@
+package mempool
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func newTx(id byte, runlimit int64) *bc.Tx {
+	return &bc.Tx{ID: bc.Hash{V0: uint64(id)}, RawTx: bc.RawTx{Runlimit: runlimit}}
+}
+
+func TestAddDuplicate(t *testing.T) {
+	p := New(Config{})
+	tx := newTx(1, 100)
+	if err := p.Add(tx, "alice", 10); err != nil {
+		t.Fatalf("first Add: %v", err)
+	}
+	if err := p.Add(tx, "alice", 10); err != ErrDuplicate {
+		t.Fatalf("second Add: got %v, want ErrDuplicate", err)
+	}
+}
+
+func TestAddEvictsLowestPriority(t *testing.T) {
+	p := New(Config{MaxSize: 2})
+	low := newTx(1, 10)    // priority 1.0
+	mid := newTx(2, 100)   // priority 10.0
+	high := newTx(3, 1000) // priority 100.0
+
+	if err := p.Add(low, "a", 10); err != nil {
+		t.Fatalf("Add low: %v", err)
+	}
+	if err := p.Add(mid, "a", 10); err != nil {
+		t.Fatalf("Add mid: %v", err)
+	}
+
+	// Pool is full; a higher-priority tx should evict the lowest one.
+	if err := p.Add(high, "a", 10); err != nil {
+		t.Fatalf("Add high into full pool: %v", err)
+	}
+	ids := p.IDs()
+	if len(ids) != 2 {
+		t.Fatalf("pool size = %d, want 2", len(ids))
+	}
+	for _, id := range ids {
+		if id == low.ID {
+			t.Fatalf("lowest-priority tx was not evicted")
+		}
+	}
+
+	// A tx that ranks below everything already in a full pool is
+	// rejected rather than evicting anything.
+	lower := newTx(4, 1)
+	if err := p.Add(lower, "a", 10); err != ErrFull {
+		t.Fatalf("Add lowest-priority tx into full pool: got %v, want ErrFull", err)
+	}
+}
+
+func TestPullOrdersByPriorityWithinSubmitter(t *testing.T) {
+	p := New(Config{})
+	p.Add(newTx(1, 10), "alice", 10)  // priority 1.0
+	p.Add(newTx(2, 100), "alice", 10) // priority 10.0, should pull before tx 1
+	p.Add(newTx(3, 50), "bob", 10)    // priority 5.0
+
+	out := p.Pull(1<<30, 10)
+	if len(out) != 3 {
+		t.Fatalf("Pull returned %d txs, want 3", len(out))
+	}
+
+	indexOf := func(id bc.Hash) int {
+		for i, entry := range out {
+			if entry.Tx.ID == id {
+				return i
+			}
+		}
+		t.Fatalf("tx %x missing from Pull result", id)
+		return -1
+	}
+	if indexOf(bc.Hash{V0: 2}) >= indexOf(bc.Hash{V0: 1}) {
+		t.Fatalf("alice's higher-priority tx should be pulled before her lower-priority one")
+	}
+}
+
+func TestPullRespectsRunlimitBudget(t *testing.T) {
+	p := New(Config{})
+	p.Add(newTx(1, 100), "alice", 10)
+	p.Add(newTx(2, 100), "alice", 10)
+
+	out := p.Pull(150, 10)
+	if len(out) != 1 {
+		t.Fatalf("Pull returned %d txs, want 1 (budget exhausted)", len(out))
+	}
+	if len(p.IDs()) != 1 {
+		t.Fatalf("pool should still hold the tx that didn't fit the budget")
+	}
+}
+
+func TestRequeueBounded(t *testing.T) {
+	p := New(Config{})
+	p.Add(newTx(1, 10), "alice", 10)
+
+	out := p.Pull(1<<30, 10)
+	if len(out) != 1 {
+		t.Fatalf("Pull returned %d txs, want 1", len(out))
+	}
+	entry := out[0]
+
+	for i := 0; i < maxRequeueAttempts; i++ {
+		if !p.Requeue(entry) {
+			t.Fatalf("Requeue failed on attempt %d, want success (maxRequeueAttempts=%d)", i+1, maxRequeueAttempts)
+		}
+		pulled := p.Pull(1<<30, 10)
+		if len(pulled) != 1 {
+			t.Fatalf("Pull after requeue returned %d txs, want 1", len(pulled))
+		}
+		entry = pulled[0]
+	}
+	if p.Requeue(entry) {
+		t.Fatalf("Requeue succeeded past maxRequeueAttempts")
+	}
+}
+
+func TestTTLEviction(t *testing.T) {
+	p := New(Config{TTL: time.Millisecond})
+	p.Add(newTx(1, 10), "alice", 10)
+	time.Sleep(5 * time.Millisecond)
+	if stats := p.Stats(); stats.Count != 0 {
+		t.Fatalf("Stats().Count = %d after TTL expiry, want 0", stats.Count)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// closableMutex is a sync.Mutex that can be permanently closed. Once
+// closed, TryLock always reports failure, so a goroutine driving a
+// graceful shutdown can stop new work from starting without racing
+// whichever goroutine currently holds the lock.
+//
+// Modeled on go-ethereum's internal/syncx.ClosableMutex, which serves
+// the same purpose during BlockChain shutdown.
+type closableMutex struct {
+	mu     sync.Mutex
+	closed int32
+}
+
+// Lock acquires the mutex unconditionally, closed or not. It's used
+// by the goroutine performing the shutdown itself, which must still
+// be able to take the lock to flush outstanding work.
+func (m *closableMutex) Lock() {
+	m.mu.Lock()
+}
+
+// Unlock releases the mutex.
+func (m *closableMutex) Unlock() {
+	m.mu.Unlock()
+}
+
+// TryLock acquires the mutex, unless it has been closed, in which
+// case it returns false immediately (or as soon as the current
+// holder releases it).
+func (m *closableMutex) TryLock() bool {
+	if atomic.LoadInt32(&m.closed) != 0 {
+		return false
+	}
+	m.mu.Lock()
+	if atomic.LoadInt32(&m.closed) != 0 {
+		m.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// Close marks the mutex closed. Every subsequent call to TryLock
+// fails. Close does not itself acquire the mutex, so it never blocks
+// on an in-flight holder; callers that need to wait for in-flight
+// work to finish should do so separately (see submitter.Close).
+func (m *closableMutex) Close() {
+	atomic.StoreInt32(&m.closed, 1)
+}
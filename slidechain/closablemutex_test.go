@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClosableMutexTryLock(t *testing.T) {
+	var m closableMutex
+	if !m.TryLock() {
+		t.Fatal("TryLock failed on a fresh mutex")
+	}
+	m.Unlock()
+
+	m.Close()
+	if m.TryLock() {
+		t.Fatal("TryLock succeeded after Close")
+	}
+}
+
+func TestClosableMutexLockStillWorksAfterClose(t *testing.T) {
+	var m closableMutex
+	m.Close()
+
+	done := make(chan struct{})
+	go func() {
+		m.Lock()
+		m.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Lock blocked forever after Close")
+	}
+}
+
+func TestClosableMutexTryLockRacesHolder(t *testing.T) {
+	var m closableMutex
+	m.Lock()
+
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	<-closed
+
+	done := make(chan struct{})
+	go func() {
+		if m.TryLock() {
+			t.Error("TryLock succeeded after Close while another goroutine held the mutex")
+		}
+		close(done)
+	}()
+
+	m.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("TryLock blocked forever")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// errWrongKeySize is wrapped with the offending path so the error
+// names the keyfile that's the wrong size.
+var errWrongKeySize = errors.New("signer keyfile is the wrong size for an ed25519 private key")
+
+// ed25519Signer signs blocks with a key loaded from a local keyfile.
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+// newEd25519Signer loads an ed25519 private key from path.
+func newEd25519Signer(path string) (*ed25519Signer, error) {
+	bits, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading signer keyfile")
+	}
+	if len(bits) != ed25519.PrivateKeySize {
+		return nil, errors.Wrapf(errWrongKeySize, "%s", path)
+	}
+	return &ed25519Signer{key: ed25519.PrivateKey(bits)}, nil
+}
+
+// PublicKey implements BlockSigner.
+func (s *ed25519Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign implements BlockSigner.
+func (s *ed25519Signer) Sign(ctx context.Context, b *bc.UnsignedBlock) ([]byte, error) {
+	h := b.Hash()
+	return ed25519.Sign(s.key, h.Bytes()), nil
+}
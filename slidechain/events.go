@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// blockEvent is the JSON payload of each "block" server-sent event
+// emitted by serveEvents.
+type blockEvent struct {
+	Height  uint64   `json:"height"`
+	TxCount int      `json:"tx_count"`
+	TxIDs   []string `json:"tx_ids"`
+}
+
+// serveEvents handles GET /events, streaming newly committed blocks
+// to the client as server-sent events, layered on the same
+// multichan.W that feeds any other blockchain follower. Each event
+// includes the IDs of the txs the block contains, so a subscriber can
+// tell whether and when its own tx was included without polling
+// /mempool or /pending. This lets external services subscribe to
+// commits and tx inclusions without polling, mirroring go-ethereum's
+// ChainHeadEvent/NewTxsEvent feeds.
+func (s *submitter) serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpErrf(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ctx := req.Context()
+	r := s.w.Reader()
+	for {
+		v, ok := r.Read(ctx)
+		if !ok {
+			return
+		}
+		cb, ok := v.(*committedBlock)
+		if !ok {
+			continue
+		}
+
+		txIDs := make([]string, len(cb.TxIDs))
+		for i, id := range cb.TxIDs {
+			txIDs[i] = id.String()
+		}
+		event := blockEvent{
+			Height:  cb.Block.Height,
+			TxCount: len(cb.Block.Transactions),
+			TxIDs:   txIDs,
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+
+		fmt.Fprintf(w, "event: block\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+}
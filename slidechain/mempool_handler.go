@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// mempoolResponse is the JSON body served by GET /mempool.
+type mempoolResponse struct {
+	TxIDs      []string `json:"tx_ids"`
+	Count      int      `json:"count"`
+	Submitters int      `json:"submitters"`
+}
+
+// serveMempool handles GET /mempool, reporting the txs currently
+// queued ahead of block assembly. Unlike servePending, this reads
+// directly from the mempool.Pool, which has its own internal locking
+// and isn't owned by the worker's mainLoop goroutine.
+func (s *submitter) serveMempool(w http.ResponseWriter, req *http.Request) {
+	ids := s.wkr.pool.IDs()
+	stats := s.wkr.pool.Stats()
+
+	resp := mempoolResponse{
+		TxIDs:      make([]string, len(ids)),
+		Count:      stats.Count,
+		Submitters: stats.Submitters,
+	}
+	for i, id := range ids {
+		resp.TxIDs[i] = id.String()
+	}
+
+	bits, err := json.Marshal(resp)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "encoding mempool response: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bits)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// metrics instruments the block-assembly path: txs accepted/rejected
+// (by reason), and histograms for block-build duration, block size in
+// both tx count and bytes, and the time from an individual tx's
+// submission to its block's commit. Published via expvar's default
+// /debug/vars handler rather than a bespoke endpoint, so any existing
+// Prometheus expvar exporter picks it up unchanged.
+var metrics = newMetricsSet()
+
+type metricsSet struct {
+	TxsAccepted *expvar.Int
+	TxsRejected *expvar.Map
+
+	BlocksCommitted       *expvar.Int
+	BlockBuildSeconds     *histogram
+	BlockSizeTxs          *histogram
+	BlockSizeBytes        *histogram
+	SubmitToCommitSeconds *histogram
+}
+
+func newMetricsSet() *metricsSet {
+	return &metricsSet{
+		TxsAccepted:           expvar.NewInt("slingshot_txs_accepted"),
+		TxsRejected:           expvar.NewMap("slingshot_txs_rejected"),
+		BlocksCommitted:       expvar.NewInt("slingshot_blocks_committed"),
+		BlockBuildSeconds:     newHistogram("slingshot_block_build_seconds"),
+		BlockSizeTxs:          newHistogram("slingshot_block_size_txs"),
+		BlockSizeBytes:        newHistogram("slingshot_block_size_bytes"),
+		SubmitToCommitSeconds: newHistogram("slingshot_submit_to_commit_seconds"),
+	}
+}
+
+func (ms *metricsSet) rejectTx(reason string) {
+	ms.TxsRejected.Add(reason, 1)
+}
+
+// histogram is a minimal, dependency-free stand-in for a Prometheus
+// histogram: count, sum, min, and max, published as a JSON object via
+// expvar.
+type histogram struct {
+	mu    sync.Mutex
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func newHistogram(name string) *histogram {
+	h := &histogram{}
+	expvar.Publish(name, h)
+	return h
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.sum += v
+	h.count++
+}
+
+// String implements expvar.Var.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var mean float64
+	if h.count > 0 {
+		mean = h.sum / float64(h.count)
+	}
+	return fmt.Sprintf(`{"count":%d,"sum":%g,"min":%g,"max":%g,"mean":%g}`, h.count, h.sum, h.min, h.max, mean)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/chain/txvm/protocol"
+	"github.com/golang/protobuf/proto"
+)
+
+// servePending handles GET /pending. It returns a serialized
+// bc.UnsignedBlock describing the block currently being built, so
+// that clients such as wallets and explorers can preview inclusion
+// before the block is committed.
+//
+// Rather than keep a second, continuously-updated block builder
+// around, the pending block is assembled on demand: we ask the
+// worker (over previewCh) for the state, deadline, and txs it has
+// collected so far, replay them into a fresh, throwaway
+// BlockBuilder, and call Build() on that. The worker's own
+// BlockBuilder is never touched, so this has no effect on the block
+// that eventually gets committed. (This mirrors the "pending block
+// on demand" approach in go-ethereum's miner.Miner.pending.)
+func (s *submitter) servePending(w http.ResponseWriter, req *http.Request) {
+	preq := previewRequest{reply: make(chan *previewResult, 1)}
+	select {
+	case s.wkr.previewCh <- preq:
+	case <-s.wkr.exitCh:
+		httpErrf(w, http.StatusServiceUnavailable, "submitter is shutting down")
+		return
+	}
+
+	result := <-preq.reply
+	if result == nil {
+		httpErrf(w, http.StatusNotFound, "no block is currently being built")
+		return
+	}
+
+	preview := protocol.NewBlockBuilder()
+	err := preview.Start(result.state, result.deadline)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "starting pending-block preview: %s", err)
+		return
+	}
+	for _, tx := range result.txs {
+		err = preview.AddTx(tx)
+		if err != nil {
+			httpErrf(w, http.StatusInternalServerError, "replaying tx into pending-block preview: %s", err)
+			return
+		}
+	}
+
+	unsignedBlock, _, err := preview.Build()
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "assembling pending-block preview: %s", err)
+		return
+	}
+
+	bits, err := proto.Marshal(unsignedBlock)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "serializing pending block: %s", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(bits)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// errRemoteSignerStatus is wrapped with the signer's URL and the
+// response status when a remote signer declines or fails to sign.
+var errRemoteSignerStatus = errors.New("remote signer returned non-200 status")
+
+// defaultRemoteSignerTimeout bounds how long a remote signer gets to
+// respond. A remote signer is a network dependency that's expected to
+// blip occasionally; without a timeout of its own, a signer that
+// never responds would hold each request for as long as the caller's
+// context stays alive: up to signTimeout for a commit() signing
+// attempt, and the whole /status request for a health check.
+const defaultRemoteSignerTimeout = 5 * time.Second
+
+// remoteSigner asks an external signer to sign a block over HTTP,
+// allowing a quorum of remote signers (HSMs, air-gapped keys, and
+// the like) to each run their own compatible endpoint.
+type remoteSigner struct {
+	url    string
+	pubkey ed25519.PublicKey
+	client *http.Client
+}
+
+// newRemoteSigner returns a BlockSigner that POSTs an unsigned
+// block's hash to url and awaits a raw signature in the response
+// body. pubkey is the signer's half of the keypair it's expected to
+// sign with, which is how signerSet matches its signature to the
+// predicate slot that pubkey occupies. Requests are bounded by
+// defaultRemoteSignerTimeout regardless of the caller's context.
+func newRemoteSigner(url string, pubkey ed25519.PublicKey) *remoteSigner {
+	return &remoteSigner{url: url, pubkey: pubkey, client: &http.Client{Timeout: defaultRemoteSignerTimeout}}
+}
+
+// PublicKey implements BlockSigner.
+func (s *remoteSigner) PublicKey() ed25519.PublicKey {
+	return s.pubkey
+}
+
+// Sign implements BlockSigner.
+func (s *remoteSigner) Sign(ctx context.Context, b *bc.UnsignedBlock) ([]byte, error) {
+	h := b.Hash()
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(h.Bytes()))
+	if err != nil {
+		return nil, errors.Wrap(err, "building remote-signer request")
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "calling remote signer")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(errRemoteSignerStatus, "%s: %s", s.url, resp.Status)
+	}
+
+	sig, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading remote signer response")
+	}
+	return sig, nil
+}
+
+// Healthy implements HealthChecker by issuing a HEAD request against
+// the signer's URL; a remote signer is healthy if it's reachable and
+// doesn't answer with a 5xx status, independent of whether it would
+// agree to sign any particular block.
+func (s *remoteSigner) Healthy(ctx context.Context) error {
+	req, err := http.NewRequest("HEAD", s.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "building remote-signer health check")
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "checking remote signer health")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return errors.Wrapf(errRemoteSignerStatus, "%s: %s", s.url, resp.Status)
+	}
+	return nil
+}
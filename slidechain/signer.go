@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// BlockSigner signs an as-yet-uncommitted block. PublicKey identifies
+// which pubkey in the chain's predicate this signer speaks for, so
+// signerSet can place its signature in the matching Arguments slot
+// instead of assuming signers and pubkeys share the same order.
+type BlockSigner interface {
+	PublicKey() ed25519.PublicKey
+	Sign(ctx context.Context, b *bc.UnsignedBlock) ([]byte, error)
+}
+
+// HealthChecker is optionally implemented by a BlockSigner that can
+// report its own health without actually signing a block (a remote
+// signer pinging its backend, say). A signer that doesn't implement
+// it is always reported healthy by /status.
+type HealthChecker interface {
+	Healthy(ctx context.Context) error
+}
+
+// signerSet signs a block with every configured BlockSigner whose
+// PublicKey appears in the predicate governing it.
+type signerSet struct {
+	signers []BlockSigner
+}
+
+// Sign asks every signer in ss whose PublicKey matches a pubkey in
+// prev's NextPredicate to sign b, concurrently, then hands the
+// results to bc.SignBlock, which places each signature in the slot
+// its pubkey occupies and enforces the predicate's own quorum -
+// rather than some second, independently-configured number that
+// could drift from it. A signer that errors, or whose pubkey isn't
+// in the predicate at all, simply leaves its slot unsigned; Sign
+// only fails if that leaves the predicate's quorum unmet.
+func (ss *signerSet) Sign(ctx context.Context, b *bc.UnsignedBlock, prev *bc.BlockHeader) (*bc.Block, error) {
+	var pubkeys [][]byte
+	if prev != nil && prev.NextPredicate != nil {
+		pubkeys = prev.NextPredicate.Pubkeys
+	}
+
+	bySlot := make([]BlockSigner, len(pubkeys))
+	for _, signer := range ss.signers {
+		pubkey := signer.PublicKey()
+		for i, predPubkey := range pubkeys {
+			if bytes.Equal(pubkey, predPubkey) {
+				bySlot[i] = signer
+			}
+		}
+	}
+
+	sigs := make([][]byte, len(pubkeys))
+	var wg sync.WaitGroup
+	for i, signer := range bySlot {
+		if signer == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, signer BlockSigner) {
+			defer wg.Done()
+			sig, err := signer.Sign(ctx, b)
+			if err != nil {
+				log.Printf("signer for predicate slot %d failed: %s", i, err)
+				return
+			}
+			sigs[i] = sig
+		}(i, signer)
+	}
+	wg.Wait()
+
+	return bc.SignBlock(b, prev, func(i int) (interface{}, error) {
+		if sigs[i] == nil {
+			return nil, nil
+		}
+		return sigs[i], nil
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// signerStatus reports one configured signer's health as seen by
+// GET /status.
+type signerStatus struct {
+	Index   int    `json:"index"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+type statusResponse struct {
+	Signers []signerStatus `json:"signers"`
+}
+
+// serveStatus handles GET /status, reporting the health of every
+// configured BlockSigner. A signer that doesn't implement
+// HealthChecker is always reported healthy.
+func (s *submitter) serveStatus(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	signers := s.wkr.signers.signers
+	resp := statusResponse{Signers: make([]signerStatus, len(signers))}
+	for i, signer := range signers {
+		st := signerStatus{Index: i, Healthy: true}
+		if hc, ok := signer.(HealthChecker); ok {
+			if err := hc.Healthy(ctx); err != nil {
+				st.Healthy = false
+				st.Error = err.Error()
+			}
+		}
+		resp.Signers[i] = st
+	}
+
+	bits, err := json.Marshal(resp)
+	if err != nil {
+		httpErrf(w, http.StatusInternalServerError, "encoding status response: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bits)
+}
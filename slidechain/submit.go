@@ -1,33 +1,25 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"sync"
-	"time"
 
 	"github.com/bobg/multichan"
-	"github.com/chain/txvm/errors"
-	"github.com/chain/txvm/protocol"
-	"github.com/chain/txvm/protocol/bc"
 	"github.com/golang/protobuf/proto"
-)
 
-// TODO: make this configurable.
-var blockInterval = 5 * time.Second
+	"github.com/chain/txvm/protocol/bc"
+)
 
+// submitter accepts txs over HTTP and hands them to a worker, which
+// owns the actual block-assembly and commit logic (see worker.go).
 type submitter struct {
-	// Protects bb.
-	bbmu sync.Mutex
+	// Gates ServeHTTP. Closed at the start of shutdown so that new
+	// submissions are rejected instead of racing Close.
+	bbmu closableMutex
 
-	// Normally nil. Once a tx is submitted, this is set to a new block
-	// builder and a timer set. Other txs that arrive during that
-	// interval are added to the block a-building. When the timer fires,
-	// the block is added to the blockchain and this field is set back to nil.
-	//
-	// This is the only way that blocks are added to the chain.
-	bb *protocol.BlockBuilder
+	wkr *worker
 
 	// New blocks are written here.
 	// Anything monitoring the blockchain can create a reader and consume them.
@@ -35,9 +27,16 @@ type submitter struct {
 	w *multichan.W
 }
 
-func (s *submitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	ctx := req.Context()
+// newSubmitter starts a worker with cfg and signers and returns a
+// submitter ready to serve requests. The caller must call Close to
+// shut it down.
+func newSubmitter(ctx context.Context, cfg workerConfig, w *multichan.W, signers *signerSet) *submitter {
+	wkr := newWorker(cfg, w, signers)
+	wkr.start(ctx)
+	return &submitter{wkr: wkr, w: w}
+}
 
+func (s *submitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	bits, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		httpErrf(w, http.StatusInternalServerError, "reading request body: %s", err)
@@ -57,51 +56,30 @@ func (s *submitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	s.bbmu.Lock()
-	defer s.bbmu.Unlock()
-
-	if s.bb == nil {
-		s.bb = protocol.NewBlockBuilder()
-		nextBlockTime := time.Now().Add(blockInterval)
-
-		st := chain.State()
-		if st.Header == nil {
-			err = st.ApplyBlockHeader(initialBlock.BlockHeader)
-			if err != nil {
-				httpErrf(w, http.StatusInternalServerError, "initializing empty state: %s", err)
-				return
-			}
-		}
-
-		err := bb.Start(chain.State(), bc.Millis(nextBlockTime))
-		if err != nil {
-			httpErrf(w, http.StatusInternalServerError, "starting a new tx pool: %s", err)
-			return
-		}
-		log.Printf("starting new block, will commit at %s", nextBlockTime)
-		time.AfterFunc(blockInterval, func() {
-			bbmu.Lock()
-			defer bbmu.Unlock()
-
-			unsignedBlock, newSnapshot, err := bb.Build()
-			if err != nil {
-				log.Fatal(errors.Wrap(err, "building new block"))
-			}
-			b := &bc.Block{UnsignedBlock: unsignedBlock}
-			err = chain.CommitAppliedBlock(ctx, b, newSnapshot)
-			if err != nil {
-				log.Fatal(errors.Wrap(err, "committing new block"))
-			}
-
-			s.w.Write(b)
+	if err := validateTx(tx); err != nil {
+		metrics.rejectTx("invalid")
+		httpErrf(w, http.StatusBadRequest, "validating tx: %s", err)
+		return
+	}
 
-			log.Printf("committed block %d with %d transaction(s)", unsignedBlock.Height, len(unsignedBlock.Transactions))
+	// bbmu no longer guards bb itself (the worker owns that), but
+	// TryLock still fails once Close has begun, so this simply gates
+	// admission of new submissions during shutdown.
+	if !s.bbmu.TryLock() {
+		httpErrf(w, http.StatusServiceUnavailable, "submitter is shutting down")
+		return
+	}
+	s.bbmu.Unlock()
 
-			bb = nil
-		})
+	sub := txSubmission{tx: tx, submitter: req.RemoteAddr, size: len(bits), reply: make(chan error, 1)}
+	select {
+	case s.wkr.newTxCh <- sub:
+	case <-s.wkr.exitCh:
+		httpErrf(w, http.StatusServiceUnavailable, "submitter is shutting down")
+		return
 	}
 
-	err = bb.AddTx(bc.NewCommitmentsTx(tx))
+	err = <-sub.reply
 	if err != nil {
 		httpErrf(w, http.StatusBadRequest, "adding tx to pool: %s", err)
 		return
@@ -109,3 +87,21 @@ func (s *submitter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log.Printf("added tx %x to the pending block", tx.ID.Bytes())
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// Close shuts the submitter down gracefully: it stops ServeHTTP from
+// admitting new submissions and tells the worker to flush and exit
+// (see workerConfig.DiscardOnClose in worker.go). It returns early with ctx.Err()
+// if ctx is done before the worker finishes, leaving the worker to
+// finish shutting down in the background - so w isn't closed until
+// wkr.done actually closes, however long that takes, since the
+// worker's shutdown retry loop can still call w.Write after Close has
+// returned.
+func (s *submitter) Close(ctx context.Context) error {
+	s.bbmu.Close()
+	err := s.wkr.close(ctx)
+	go func() {
+		<-s.wkr.done
+		s.w.Close()
+	}()
+	return err
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bobg/multichan"
+	"github.com/golang/protobuf/proto"
+
+	"github.com/chain/slingshot/mempool"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/state"
+)
+
+// workerConfig bounds how the block-assembly worker paces itself.
+// TODO: load these from flags/config instead of defaultWorkerConfig.
+type workerConfig struct {
+	// MinBlockInterval is how long a block stays open for txs under
+	// normal load.
+	MinBlockInterval time.Duration
+
+	// MaxBlockInterval is the most a block's commit can be pushed back
+	// when utilization is low; see LowUtilization.
+	MaxBlockInterval time.Duration
+
+	// MaxTxsPerBlock commits the block immediately once reached.
+	MaxTxsPerBlock int
+
+	// MaxRunlimit commits the block immediately once the sum of its
+	// txs' runlimits reaches this.
+	MaxRunlimit int64
+
+	// LowUtilization is a fraction of MaxTxsPerBlock; if a block's
+	// interval elapses with fewer txs than this fraction, the commit
+	// is pushed back (doubling the interval, up to MaxBlockInterval)
+	// instead of firing, so light load doesn't produce a stream of
+	// near-empty blocks.
+	LowUtilization float64
+
+	// Mempool configures the pool that txs land in ahead of being
+	// pulled into a block; see mempool.Config.
+	Mempool mempool.Config
+
+	// DiscardOnClose controls what the worker does, on exitCh, with a
+	// block still under construction: discard its txs (true) or commit
+	// them immediately (false) before mainLoop returns.
+	DiscardOnClose bool
+}
+
+// defaultWorkerConfig reproduces the old fixed 5-second interval,
+// with tx/runlimit caps generous enough that they never fire unless
+// an operator configures tighter limits.
+var defaultWorkerConfig = workerConfig{
+	MinBlockInterval: 5 * time.Second,
+	MaxBlockInterval: 5 * time.Second,
+	MaxTxsPerBlock:   1000,
+	MaxRunlimit:      1 << 30,
+	LowUtilization:   0.1,
+	Mempool: mempool.Config{
+		MaxSize: 10000,
+		TTL:     time.Minute,
+	},
+	DiscardOnClose: true,
+}
+
+// validateTx checks tx against the chain's latest committed state by
+// attempting to fold it into a throwaway BlockBuilder, so a tx that's
+// already invalid (e.g. it spends an output that's been spent or
+// never existed) is rejected up front instead of being admitted to
+// the mempool and only discovered dead when fillFromPool tries to add
+// it to the block under construction. It can't catch a tx that only
+// conflicts with another tx pulled into the same in-progress block;
+// fillFromPool's Requeue handles that case instead.
+//
+// It's called from submitter.ServeHTTP, ahead of enqueueing onto
+// wkr.newTxCh, rather than from mainLoop: chain.State() is safe for
+// concurrent callers, so there's no reason to serialize validation
+// with mainLoop's block assembly and make it wait behind whatever
+// mainLoop happens to be doing (building, signing, or committing a
+// block) at submission time.
+func validateTx(tx *bc.Tx) error {
+	st := chain.State()
+	if st.Header == nil {
+		// Nothing has been committed yet; there's no snapshot to
+		// validate against, so let it through (startBlock will apply
+		// initialBlock.BlockHeader before anything gets built).
+		return nil
+	}
+	bb := protocol.NewBlockBuilder()
+	deadline := bc.Millis(time.Now())
+	if deadline <= st.TimestampMS() {
+		// A tx submitted within the same millisecond as the last
+		// committed block (routine right after startup, and a live
+		// risk under fast block times) would otherwise make Start
+		// reject the snapshot for not moving the clock forward.
+		deadline = st.TimestampMS() + 1
+	}
+	err := bb.Start(st, deadline)
+	if err != nil {
+		return errors.Wrap(err, "starting validation snapshot")
+	}
+	return bb.AddTx(bc.NewCommitmentsTx(tx))
+}
+
+// rejectReason classifies a mempool.Pool.Add error for the
+// slingshot_txs_rejected metric.
+func rejectReason(err error) string {
+	switch err {
+	case mempool.ErrDuplicate:
+		return "duplicate"
+	case mempool.ErrFull:
+		return "full"
+	default:
+		return "invalid"
+	}
+}
+
+// signTimeout bounds how long a single commit attempt will wait on
+// wkr.signers.Sign, independent of any per-signer timeout (see
+// remoteSigner), so a misbehaving BlockSigner implementation can't
+// hang commit() past this. signRetryInterval is how long commit
+// waits before re-triggering itself after a recoverable signing
+// failure.
+const (
+	signTimeout       = 10 * time.Second
+	signRetryInterval = 2 * time.Second
+)
+
+// commitReason says why a value arrived on commitCh, so mainLoop can
+// tell an early tx/runlimit-cap commit - a genuine high-utilization
+// signal that should reset the adaptive interval - apart from a
+// signing-failure retry, which says nothing about load and must leave
+// interval alone.
+type commitReason int
+
+const (
+	commitTimerFired commitReason = iota
+	commitCapReached
+	commitSignRetry
+)
+
+// txSubmission is enqueued on newTxCh by submitter.ServeHTTP. The
+// worker replies on reply once tx has been added to (or rejected by)
+// the mempool; submitter and size feed the pool's default priority
+// function and per-submitter queues (see mempool.Pool).
+type txSubmission struct {
+	tx        *bc.Tx
+	submitter string
+	size      int
+	reply     chan error
+}
+
+// committedBlock is what gets written to wkr.w on every commit. It
+// bundles the committed block with the IDs of the txs it contains, in
+// order, so a consumer like serveEvents can report tx inclusion
+// without a second lookup against the chain.
+type committedBlock struct {
+	Block *bc.Block
+	TxIDs []bc.Hash
+}
+
+// previewRequest is enqueued on previewCh by submitter.servePending.
+type previewRequest struct {
+	reply chan *previewResult
+}
+
+// previewResult is the state needed to assemble an on-demand preview
+// of the block currently under construction; see pending.go. It's
+// nil if no block is currently being built.
+type previewResult struct {
+	state    *state.Snapshot
+	deadline uint64
+	txs      []*bc.CommitmentsTx
+}
+
+// blockState tracks the block the worker currently has open.
+type blockState struct {
+	bb           *protocol.BlockBuilder
+	state        *state.Snapshot
+	deadline     uint64
+	txs          []*bc.CommitmentsTx
+	runlimitUsed int64
+
+	// txIDs and submittedAt are parallel to txs: submittedAt[i] is
+	// when txIDs[i] was added to the mempool, so commit() can observe
+	// each tx's submit-to-commit latency individually rather than
+	// approximating every tx in the block with the block's own
+	// open-to-commit time.
+	txIDs       []bc.Hash
+	submittedAt []time.Time
+
+	// built and builtSnapshot cache the result of bb.Build() once
+	// commit() has called it, so a signing failure can be retried
+	// without calling Build() (which isn't safe to call twice) again.
+	built         *bc.UnsignedBlock
+	builtSnapshot *state.Snapshot
+}
+
+// worker owns the BlockBuilder lifecycle. All access to the
+// in-progress block happens on mainLoop's goroutine; everything else
+// (HTTP handlers) talks to it only over channels, analogous to
+// go-ethereum's miner/worker.go.
+type worker struct {
+	cfg     workerConfig
+	w       *multichan.W
+	pool    *mempool.Pool
+	signers *signerSet
+
+	newTxCh   chan txSubmission
+	previewCh chan previewRequest
+	commitCh  chan commitReason
+	exitCh    chan struct{}
+	done      chan struct{}
+
+	// exitErr is set by mainLoop, if at all, before it closes done, so
+	// close can report it without a lock: the close of done happens
+	// after the write, so close reading exitErr after <-done never
+	// races it. It holds the error (if any) from the shutdown retry
+	// loop's last commit attempt, or ctx.Err() if ctx ran out first.
+	exitErr error
+}
+
+func newWorker(cfg workerConfig, w *multichan.W, signers *signerSet) *worker {
+	return &worker{
+		cfg:       cfg,
+		w:         w,
+		pool:      mempool.New(cfg.Mempool),
+		signers:   signers,
+		newTxCh:   make(chan txSubmission),
+		previewCh: make(chan previewRequest),
+		commitCh:  make(chan commitReason, 1),
+		exitCh:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// start runs mainLoop in its own goroutine.
+func (wkr *worker) start(ctx context.Context) {
+	go wkr.mainLoop(ctx)
+}
+
+// close asks the worker to flush (per cfg.DiscardOnClose) and stop, and
+// waits for mainLoop to return or ctx to be done, whichever comes
+// first. If mainLoop's own shutdown retry loop gave up on committing
+// the in-flight block, that error comes back here instead of nil.
+func (wkr *worker) close(ctx context.Context) error {
+	close(wkr.exitCh)
+	select {
+	case <-wkr.done:
+		return wkr.exitErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// mainLoop is the worker's only goroutine. It owns cur (the block
+// under construction) and interval (the current commit deadline,
+// which adaptive resubmit may stretch between MinBlockInterval and
+// MaxBlockInterval) for as long as the worker runs.
+func (wkr *worker) mainLoop(ctx context.Context) {
+	defer close(wkr.done)
+
+	interval := wkr.cfg.MinBlockInterval
+	var (
+		cur   *blockState
+		timer *time.Timer
+	)
+
+	// drainTicker fires fillFromPool independently of newTxCh, so a
+	// burst that overflows MaxTxsPerBlock/MaxRunlimit and is then
+	// followed by silence still gets its leftover pooled txs folded
+	// into (and eventually committed in) a block, instead of sitting
+	// until mempool.Config.TTL evicts them.
+	drainTicker := time.NewTicker(wkr.cfg.MinBlockInterval)
+	defer drainTicker.Stop()
+
+	startBlock := func() {
+		bb := protocol.NewBlockBuilder()
+		nextBlockTime := time.Now().Add(interval)
+
+		st := chain.State()
+		if st.Header == nil {
+			err := st.ApplyBlockHeader(initialBlock.BlockHeader)
+			if err != nil {
+				log.Fatal(errors.Wrap(err, "initializing empty state"))
+			}
+			st = chain.State()
+		}
+
+		deadline := bc.Millis(nextBlockTime)
+		err := bb.Start(st, deadline)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "starting a new tx pool"))
+		}
+		cur = &blockState{bb: bb, state: st, deadline: deadline}
+		timer = time.NewTimer(interval)
+		log.Printf("starting new block, will commit at %s", nextBlockTime)
+	}
+
+	// commit builds (if it hasn't already) and signs the block under
+	// construction, then commits it. A signing failure - a remote
+	// signer blipping, say - is recoverable: it's logged and a retry
+	// is scheduled rather than taking down the worker, and the cached
+	// build result means the retry doesn't call bb.Build() again
+	// (which isn't safe to call twice). A build or chain-apply failure
+	// is returned instead of fataling the process; the caller drops
+	// the block rather than retrying it indefinitely, since neither
+	// failure is expected to clear on its own the way a signer blip
+	// can.
+	commit := func() error {
+		if cur.built == nil {
+			buildStart := time.Now()
+			unsignedBlock, newSnapshot, err := cur.bb.Build()
+			metrics.BlockBuildSeconds.observe(time.Since(buildStart).Seconds())
+			if err != nil {
+				return errors.Wrap(err, "building new block")
+			}
+			cur.built = unsignedBlock
+			cur.builtSnapshot = newSnapshot
+		}
+
+		signCtx, cancel := context.WithTimeout(ctx, signTimeout)
+		b, err := wkr.signers.Sign(signCtx, cur.built, cur.state.Header)
+		cancel()
+		if err != nil {
+			log.Printf("signing block %d failed, will retry in %s: %s", cur.built.Height, signRetryInterval, err)
+			time.AfterFunc(signRetryInterval, func() {
+				select {
+				case wkr.commitCh <- commitSignRetry:
+				case <-wkr.exitCh:
+				}
+			})
+			return nil
+		}
+
+		err = chain.CommitAppliedBlock(ctx, b, cur.builtSnapshot)
+		if err != nil {
+			return errors.Wrap(err, "committing new block")
+		}
+
+		wkr.w.Write(&committedBlock{Block: b, TxIDs: cur.txIDs})
+		log.Printf("committed block %d with %d transaction(s)", cur.built.Height, len(cur.built.Transactions))
+
+		metrics.BlocksCommitted.Add(1)
+		metrics.BlockSizeTxs.observe(float64(len(cur.built.Transactions)))
+		if bits, err := proto.Marshal(b); err != nil {
+			log.Printf("measuring block %d size: %s", cur.built.Height, err)
+		} else {
+			metrics.BlockSizeBytes.observe(float64(len(bits)))
+		}
+		now := time.Now()
+		for _, at := range cur.submittedAt {
+			metrics.SubmitToCommitSeconds.observe(now.Sub(at).Seconds())
+		}
+
+		cur = nil
+		timer.Stop()
+		timer = nil
+		return nil
+	}
+
+	// fillFromPool tops up the block under construction (starting one
+	// if needed) with whatever the mempool has room to give it, up to
+	// the remaining tx-count and runlimit budget. Once cur.built is
+	// set, cur.bb has already had Build() called on it and can no
+	// longer accept txs; fillFromPool is a no-op until commit()
+	// finishes (or fails and retries) and clears cur, so a tx can't be
+	// pulled from the pool into a block that's already frozen for
+	// signing.
+	fillFromPool := func() {
+		if cur != nil && cur.built != nil {
+			return
+		}
+		if cur == nil {
+			startBlock()
+		}
+		remainingTxs := wkr.cfg.MaxTxsPerBlock - len(cur.txs)
+		remainingRunlimit := wkr.cfg.MaxRunlimit - cur.runlimitUsed
+		if remainingTxs <= 0 || remainingRunlimit <= 0 {
+			return
+		}
+		for _, entry := range wkr.pool.Pull(remainingRunlimit, remainingTxs) {
+			commitmentsTx := bc.NewCommitmentsTx(entry.Tx)
+			err := cur.bb.AddTx(commitmentsTx)
+			if err != nil {
+				if wkr.pool.Requeue(entry) {
+					log.Printf("pooled tx %x conflicted with block in progress, requeuing (attempt %d): %s", entry.Tx.ID.Bytes(), entry.Attempts, err)
+					metrics.rejectTx("requeue")
+				} else {
+					log.Printf("dropping pooled tx %x after %d failed attempts: %s", entry.Tx.ID.Bytes(), entry.Attempts, err)
+					metrics.rejectTx("build")
+				}
+				continue
+			}
+			cur.txs = append(cur.txs, commitmentsTx)
+			cur.txIDs = append(cur.txIDs, entry.Tx.ID)
+			cur.submittedAt = append(cur.submittedAt, entry.Added)
+			cur.runlimitUsed += entry.Tx.Runlimit
+		}
+	}
+
+	for {
+		var tch <-chan time.Time
+		if timer != nil {
+			tch = timer.C
+		}
+
+		select {
+		case sub := <-wkr.newTxCh:
+			// sub.tx has already been validated against chain state by
+			// submitter.ServeHTTP, ahead of this send, so mainLoop here
+			// only has to worry about admitting it to the pool; that
+			// keeps this goroutine doing nothing but block assembly.
+			err := wkr.pool.Add(sub.tx, sub.submitter, sub.size)
+			sub.reply <- err
+			if err != nil {
+				metrics.rejectTx(rejectReason(err))
+				continue
+			}
+			metrics.TxsAccepted.Add(1)
+
+			fillFromPool()
+			if cur != nil && (len(cur.txs) >= wkr.cfg.MaxTxsPerBlock || cur.runlimitUsed >= wkr.cfg.MaxRunlimit) {
+				select {
+				case wkr.commitCh <- commitCapReached:
+				default:
+				}
+			}
+
+		case <-tch:
+			select {
+			case wkr.commitCh <- commitTimerFired:
+			default:
+			}
+
+		case <-drainTicker.C:
+			if wkr.pool.Stats().Count == 0 {
+				continue
+			}
+			fillFromPool()
+			if cur != nil && (len(cur.txs) >= wkr.cfg.MaxTxsPerBlock || cur.runlimitUsed >= wkr.cfg.MaxRunlimit) {
+				select {
+				case wkr.commitCh <- commitCapReached:
+				default:
+				}
+			}
+
+		case reason := <-wkr.commitCh:
+			if cur == nil {
+				continue
+			}
+			switch reason {
+			case commitCapReached:
+				// Hitting the tx/runlimit cap early is itself a
+				// high-utilization signal, so reset interval the same
+				// as a normal high-utilization commit below; otherwise
+				// a stretched interval from an earlier low-utilization
+				// lull would carry over into the next block even
+				// though load has clearly picked back up.
+				log.Printf("block hit tx/runlimit cap early, committing now")
+				interval = wkr.cfg.MinBlockInterval
+			case commitSignRetry:
+				// A flaky signer says nothing about load, so interval
+				// is left exactly as it was.
+				log.Printf("retrying block commit after an earlier signing failure")
+			default: // commitTimerFired
+				utilization := float64(len(cur.txs)) / float64(wkr.cfg.MaxTxsPerBlock)
+				if utilization < wkr.cfg.LowUtilization && interval < wkr.cfg.MaxBlockInterval {
+					interval *= 2
+					if interval > wkr.cfg.MaxBlockInterval {
+						interval = wkr.cfg.MaxBlockInterval
+					}
+					log.Printf("low utilization (%.0f%% of max txs/block), extending block interval to %s", utilization*100, interval)
+					timer.Reset(interval)
+					continue
+				}
+				interval = wkr.cfg.MinBlockInterval
+			}
+			fillFromPool()
+			if err := commit(); err != nil {
+				log.Printf("dropping block after commit error: %s", err)
+				cur = nil
+				if timer != nil {
+					timer.Stop()
+					timer = nil
+				}
+			}
+
+		case req := <-wkr.previewCh:
+			if cur == nil {
+				req.reply <- nil
+				continue
+			}
+			txs := make([]*bc.CommitmentsTx, len(cur.txs))
+			copy(txs, cur.txs)
+			req.reply <- &previewResult{state: cur.state, deadline: cur.deadline, txs: txs}
+
+		case <-wkr.exitCh:
+			if cur != nil {
+				if wkr.cfg.DiscardOnClose {
+					log.Printf("discarding unfinished block on shutdown")
+				} else {
+					// commit() schedules its own retry on wkr.commitCh,
+					// but mainLoop is no longer around to read that
+					// channel once it returns, so retry here directly
+					// instead, bounded by ctx, until cur is committed
+					// (commit clears it on success), commit() errors
+					// outright, or we give up.
+					for cur != nil {
+						err := commit()
+						if err != nil {
+							log.Printf("giving up on in-flight block commit during shutdown: %s", err)
+							wkr.exitErr = err
+							break
+						}
+						if cur == nil {
+							break
+						}
+						select {
+						case <-time.After(signRetryInterval):
+						case <-ctx.Done():
+							log.Printf("giving up on in-flight block commit during shutdown: %s", ctx.Err())
+							wkr.exitErr = ctx.Err()
+							return
+						}
+					}
+				}
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bobg/multichan"
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/prottest"
+	"github.com/chain/txvm/protocol/txvm/asm"
+)
+
+// flakySigner is a BlockSigner stub for exercising commit()'s retry
+// path without a real remote signer: it fails the first failures
+// calls to Sign and succeeds after that, signing with key. signerSet
+// only calls a signer whose PublicKey is in the chain's predicate, so
+// on prottest.NewChain(t)'s default 0/0 predicate (which has no
+// pubkeys) a flakySigner with no key is never called at all; only a
+// test that gives it a key from a chain with block signers reaches
+// its failures.
+type flakySigner struct {
+	failures int32
+	key      ed25519.PrivateKey
+}
+
+func (s *flakySigner) PublicKey() ed25519.PublicKey {
+	if s.key == nil {
+		return nil
+	}
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+func (s *flakySigner) Sign(ctx context.Context, b *bc.UnsignedBlock) ([]byte, error) {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return nil, errors.New("signer unavailable")
+	}
+	if s.key == nil {
+		return []byte("sig"), nil
+	}
+	h := b.Hash()
+	return ed25519.Sign(s.key, h.Bytes()), nil
+}
+
+// newTestWorker starts a worker against a fresh in-memory chain and
+// returns it along with a reader of its committed blocks. Callers are
+// responsible for calling wkr.close; ctx is canceled when the test
+// completes as a backstop against a leaked mainLoop goroutine.
+func newTestWorker(t *testing.T, cfg workerConfig, signer BlockSigner) (*worker, *multichan.R) {
+	t.Helper()
+	chain = prottest.NewChain(t)
+	initialBlock = prottest.Initial(t, chain)
+
+	w := multichan.New((*committedBlock)(nil))
+	wkr := newWorker(cfg, w, &signerSet{signers: []BlockSigner{signer}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	wkr.start(ctx)
+	return wkr, w.Reader()
+}
+
+// testNonceSeq hands out increasing nonce expirations so concurrent
+// calls to testTx never collide on the same tx ID.
+var testNonceSeq int64
+
+// testTx builds a minimal finalized transaction - one that just logs a
+// zero-blockid nonce and finalizes - so tests can exercise the worker
+// without constructing a real payment program. Its ID is unique per
+// call (the nonce expiration is tagged with an increasing counter) and
+// it needs no input already present on the chain, so validateTx always
+// accepts it.
+func testTx(t *testing.T, runlimit int64) *bc.Tx {
+	t.Helper()
+	seq := atomic.AddInt64(&testNonceSeq, 1)
+	expMS := int64(bc.Millis(time.Now())) + time.Hour.Milliseconds() + seq
+	src := fmt.Sprintf("x'%s' %d nonce\nfinalize\n", strings.Repeat("00", 32), expMS)
+	tx, err := bc.NewTx(asm.MustAssemble(src), 3, runlimit)
+	if err != nil {
+		t.Fatalf("building test tx: %s", err)
+	}
+	return tx
+}
+
+// submitTx enqueues tx onto wkr.newTxCh the way submitter.ServeHTTP
+// does once it's decided to admit tx, and fails the test if it's
+// rejected. It skips ServeHTTP's validateTx call, since every tx
+// these tests build is already valid against a freshly-created chain.
+func submitTx(t *testing.T, wkr *worker, tx *bc.Tx) {
+	t.Helper()
+	sub := txSubmission{tx: tx, submitter: "alice", size: 10, reply: make(chan error, 1)}
+	wkr.newTxCh <- sub
+	if err := <-sub.reply; err != nil {
+		t.Fatalf("submitting tx: %s", err)
+	}
+}
+
+func readCommittedBlock(t *testing.T, r *multichan.R, timeout time.Duration) *committedBlock {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	v, ok := r.Read(ctx)
+	if !ok {
+		t.Fatalf("waiting for committed block: context done: %s", ctx.Err())
+	}
+	cb, ok := v.(*committedBlock)
+	if !ok {
+		t.Fatalf("multichan produced %T, want *committedBlock", v)
+	}
+	return cb
+}
+
+func TestMainLoopCommitsOnTxCap(t *testing.T) {
+	cfg := defaultWorkerConfig
+	cfg.MinBlockInterval = 20 * time.Millisecond
+	cfg.MaxBlockInterval = 500 * time.Millisecond
+	cfg.MaxTxsPerBlock = 2
+	cfg.LowUtilization = 0
+
+	wkr, r := newTestWorker(t, cfg, &flakySigner{})
+	defer wkr.close(context.Background())
+	for i := 0; i < 2; i++ {
+		submitTx(t, wkr, testTx(t, 1000))
+	}
+
+	cb := readCommittedBlock(t, r, time.Second)
+	if len(cb.TxIDs) != 2 {
+		t.Fatalf("committed block has %d txs, want 2", len(cb.TxIDs))
+	}
+}
+
+func TestMainLoopForcedCommitResetsInterval(t *testing.T) {
+	cfg := defaultWorkerConfig
+	cfg.MinBlockInterval = 10 * time.Millisecond
+	cfg.MaxBlockInterval = time.Second
+	cfg.MaxTxsPerBlock = 20
+	cfg.LowUtilization = 0.5
+
+	wkr, r := newTestWorker(t, cfg, &flakySigner{})
+	defer wkr.close(context.Background())
+
+	// A single tx is well below LowUtilization, so each MinBlockInterval
+	// tick stretches interval instead of committing; let several ticks
+	// pass so interval is stretched several multiples above
+	// MinBlockInterval.
+	submitTx(t, wkr, testTx(t, 1000))
+	time.Sleep(350 * time.Millisecond)
+
+	// Filling the same block to its tx cap force-commits it; a forced
+	// commit is itself a high-utilization signal and must reset
+	// interval, not leave it stretched at the low-utilization value.
+	for i := 0; i < 19; i++ {
+		submitTx(t, wkr, testTx(t, 1000))
+	}
+	first := readCommittedBlock(t, r, time.Second)
+	if len(first.TxIDs) != 20 {
+		t.Fatalf("first committed block has %d txs, want 20", len(first.TxIDs))
+	}
+
+	// The next block gets exactly enough txs to be at LowUtilization,
+	// which commits on its own timer rather than by hitting the tx cap.
+	// If interval was reset, that commit follows close behind
+	// MinBlockInterval; if the bug were still present, interval would
+	// still be stretched from the first block and this read would time
+	// out well before the commit happens.
+	for i := 0; i < 10; i++ {
+		submitTx(t, wkr, testTx(t, 1000))
+	}
+	second := readCommittedBlock(t, r, 100*time.Millisecond)
+	if len(second.TxIDs) != 10 {
+		t.Fatalf("second committed block has %d txs, want 10", len(second.TxIDs))
+	}
+}
+
+func TestMainLoopDiscardOnCloseDropsOpenBlock(t *testing.T) {
+	cfg := defaultWorkerConfig
+	cfg.MinBlockInterval = time.Minute
+	cfg.MaxBlockInterval = time.Minute
+	cfg.MaxTxsPerBlock = 10
+	cfg.DiscardOnClose = true
+
+	wkr, r := newTestWorker(t, cfg, &flakySigner{})
+	submitTx(t, wkr, testTx(t, 1000))
+
+	if err := wkr.close(context.Background()); err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, ok := r.Read(ctx); ok {
+		t.Fatal("expected no committed block, but one was produced")
+	}
+}
+
+func TestMainLoopCloseRetriesCommitUntilSignerRecovers(t *testing.T) {
+	cfg := defaultWorkerConfig
+	cfg.MinBlockInterval = time.Minute
+	cfg.MaxBlockInterval = time.Minute
+	cfg.MaxTxsPerBlock = 10
+	cfg.DiscardOnClose = false
+
+	// The chain's 1-of-1 predicate needs this signer's signature, so
+	// one failure means commit() fails once and the exit-path retry
+	// loop (worker.go) waits out a single signRetryInterval before
+	// trying again and succeeding.
+	chain = prottest.NewChain(t, prottest.WithBlockSigners(1, 1))
+	initialBlock = prottest.Initial(t, chain)
+	_, privkeys := prottest.BlockKeyPairs(chain)
+	signer := &flakySigner{failures: 1, key: privkeys[0]}
+
+	w := multichan.New((*committedBlock)(nil))
+	wkr := newWorker(cfg, w, &signerSet{signers: []BlockSigner{signer}})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	wkr.start(ctx)
+	r := w.Reader()
+	submitTx(t, wkr, testTx(t, 1000))
+
+	closeDone := make(chan error, 1)
+	go func() { closeDone <- wkr.close(context.Background()) }()
+
+	const grace = 3 * time.Second // > one signRetryInterval
+	cb := readCommittedBlock(t, r, grace)
+	if len(cb.TxIDs) != 1 {
+		t.Fatalf("committed block has %d txs, want 1", len(cb.TxIDs))
+	}
+	select {
+	case err := <-closeDone:
+		if err != nil {
+			t.Fatalf("close: %s", err)
+		}
+	case <-time.After(grace):
+		t.Fatal("close did not return after the in-flight block committed")
+	}
+}
+
+// TestMainLoopSignsAtPredicateSlots runs against a chain whose
+// predicate requires 2-of-3 signatures, with signers configured in
+// an order that doesn't match their pubkeys' positions in the
+// predicate and with the middle slot's signer missing entirely. It
+// proves commit() places each signature by the pubkey it belongs to,
+// not by signer-list order, and still reaches the predicate's own
+// quorum rather than some separately-configured one.
+func TestMainLoopSignsAtPredicateSlots(t *testing.T) {
+	cfg := defaultWorkerConfig
+	cfg.MinBlockInterval = 20 * time.Millisecond
+	cfg.MaxBlockInterval = 20 * time.Millisecond
+	cfg.MaxTxsPerBlock = 1000
+
+	const n = 3
+	chain = prottest.NewChain(t, prottest.WithBlockSigners(2, n))
+	initialBlock = prottest.Initial(t, chain)
+	pubkeys, privkeys := prottest.BlockKeyPairs(chain)
+
+	signers := &signerSet{signers: []BlockSigner{
+		&ed25519Signer{key: privkeys[2]},
+		&ed25519Signer{key: privkeys[0]},
+	}}
+
+	w := multichan.New((*committedBlock)(nil))
+	wkr := newWorker(cfg, w, signers)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	wkr.start(ctx)
+	defer wkr.close(context.Background())
+
+	submitTx(t, wkr, testTx(t, 1000))
+	cb := readCommittedBlock(t, w.Reader(), time.Second)
+
+	if len(cb.Block.Arguments) != n {
+		t.Fatalf("got %d block arguments, want %d", len(cb.Block.Arguments), n)
+	}
+	hash := cb.Block.Hash()
+	for i, pubkey := range pubkeys {
+		sig, _ := cb.Block.Arguments[i].([]byte)
+		if i == 1 {
+			if sig != nil {
+				t.Fatalf("slot %d: expected no signer configured for this pubkey, got a signature anyway", i)
+			}
+			continue
+		}
+		if sig == nil {
+			t.Fatalf("slot %d: expected a signature, got none", i)
+		}
+		if !ed25519.Verify(pubkey, hash.Bytes(), sig) {
+			t.Fatalf("slot %d: signature doesn't verify against the pubkey in that predicate slot", i)
+		}
+	}
+}